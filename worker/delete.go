@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// deleteMessageBatchMaxSize is the limit SQS places on a single
+// DeleteMessageBatch call.
+const deleteMessageBatchMaxSize = 10
+
+// batchDeleter accumulates the receipt handles of successfully processed
+// messages and deletes them with DeleteMessageBatch calls of up to
+// Config.DeleteBatchSize entries, instead of one DeleteMessage call per
+// message. It is safe for concurrent use by the goroutine-per-message and
+// worker-pool paths alike.
+type batchDeleter struct {
+	config *Config
+	svc    SQSClient
+
+	maxSize int
+
+	mu      sync.Mutex
+	entries []*sqs.DeleteMessageBatchRequestEntry
+}
+
+func newBatchDeleter(config *Config, svc SQSClient) *batchDeleter {
+	maxSize := config.DeleteBatchSize
+	if maxSize <= 0 || maxSize > deleteMessageBatchMaxSize {
+		maxSize = deleteMessageBatchMaxSize
+	}
+	return &batchDeleter{
+		config:  config,
+		svc:     svc,
+		maxSize: maxSize,
+		entries: make([]*sqs.DeleteMessageBatchRequestEntry, 0, maxSize),
+	}
+}
+
+// add queues m for batch deletion, flushing immediately if this fills the batch.
+func (d *batchDeleter) add(m *sqs.Message) {
+	d.mu.Lock()
+	d.entries = append(d.entries, &sqs.DeleteMessageBatchRequestEntry{
+		Id:            m.MessageId,
+		ReceiptHandle: m.ReceiptHandle,
+	})
+	full := len(d.entries) >= d.maxSize
+	d.mu.Unlock()
+
+	if full {
+		d.flush()
+	}
+}
+
+// flush issues a DeleteMessageBatch call for whatever is currently queued, if
+// anything. Entries that SQS reports as failed are surfaced via
+// Config.OnDeleteError (if set) and always logged.
+func (d *batchDeleter) flush() {
+	d.mu.Lock()
+	if len(d.entries) == 0 {
+		d.mu.Unlock()
+		return
+	}
+	entries := d.entries
+	d.entries = make([]*sqs.DeleteMessageBatchRequestEntry, 0, d.maxSize)
+	d.mu.Unlock()
+
+	resp, err := d.svc.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(d.config.QueueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		d.config.Log.Error(fmt.Sprintf("worker: DeleteMessageBatch failed: %s", err.Error()))
+		if d.config.OnDeleteError != nil {
+			for _, e := range entries {
+				d.config.OnDeleteError(aws.StringValue(e.Id), err)
+			}
+		}
+		return
+	}
+
+	for _, failed := range resp.Failed {
+		err := fmt.Errorf("worker: failed to delete message %s: %s", aws.StringValue(failed.Id), aws.StringValue(failed.Message))
+		d.config.Log.Error(err.Error())
+		if d.config.OnDeleteError != nil {
+			d.config.OnDeleteError(aws.StringValue(failed.Id), err)
+		}
+	}
+}
+
+// startFlushTimer periodically flushes a partially-filled batch so messages
+// don't sit queued for deletion indefinitely on a quiet queue. It returns
+// immediately if Config.DeleteFlushInterval is unset, and stops once ctx is
+// cancelled.
+func (d *batchDeleter) startFlushTimer(ctx context.Context) {
+	if d.config.DeleteFlushInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(d.config.DeleteFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.flush()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,182 @@
+// Package fakesqs provides an in-memory implementation of worker.SQSClient
+// for deterministic tests that don't hit AWS or LocalStack.
+package fakesqs
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// Client is an in-memory worker.SQSClient. It tracks, per queue URL, the
+// messages waiting to be received, each message's ApproximateReceiveCount,
+// the visibility timeout last set for each receipt handle, and which
+// receipt handles have been deleted.
+type Client struct {
+	mu sync.Mutex
+
+	queues map[string][]*sqs.Message
+	nextID int
+
+	receiveCounts      map[string]int
+	visibilityTimeouts map[string]int64
+	deleted            map[string]bool
+}
+
+// New returns an empty Client.
+func New() *Client {
+	return &Client{
+		queues:             make(map[string][]*sqs.Message),
+		receiveCounts:      make(map[string]int),
+		visibilityTimeouts: make(map[string]int64),
+		deleted:            make(map[string]bool),
+	}
+}
+
+// Enqueue adds a message with the given body directly to queueURL, as if a
+// producer had just sent it, and returns its message ID.
+func (c *Client) Enqueue(queueURL, body string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.enqueueLocked(queueURL, body, nil)
+}
+
+func (c *Client) enqueueLocked(queueURL, body string, attrs map[string]*sqs.MessageAttributeValue) string {
+	c.nextID++
+	id := fmt.Sprintf("msg-%d", c.nextID)
+	m := &sqs.Message{
+		MessageId:         aws.String(id),
+		ReceiptHandle:     aws.String(id),
+		Body:              aws.String(body),
+		MessageAttributes: attrs,
+	}
+	c.queues[queueURL] = append(c.queues[queueURL], m)
+	return id
+}
+
+// SendMessage enqueues a message onto the destination queue, as RetryPolicy
+// dead-letter routing does when moving a message to a DLQ.
+func (c *Client) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := c.enqueueLocked(aws.StringValue(in.QueueUrl), aws.StringValue(in.MessageBody), in.MessageAttributes)
+	return &sqs.SendMessageOutput{MessageId: aws.String(id)}, nil
+}
+
+// ReceiveMessage returns up to MaxNumberOfMessages from the named queue and
+// bumps each returned message's ApproximateReceiveCount. Messages are not
+// removed by receiving them, only by DeleteMessage/DeleteMessageBatch — the
+// same way SQS messages remain in the queue, merely invisible, until
+// deleted or their visibility timeout expires. Client doesn't simulate
+// visibility expiry, so an undeleted message is redelivered on every call.
+func (c *Client) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	url := aws.StringValue(in.QueueUrl)
+	max := int(aws.Int64Value(in.MaxNumberOfMessages))
+	if max <= 0 {
+		max = 1
+	}
+
+	queue := c.queues[url]
+	n := len(queue)
+	if n > max {
+		n = max
+	}
+
+	messages := make([]*sqs.Message, n)
+	for i := 0; i < n; i++ {
+		m := *queue[i] // copy: the caller mutating Attributes shouldn't affect our queue
+		id := aws.StringValue(m.MessageId)
+		c.receiveCounts[id]++
+		m.Attributes = map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(fmt.Sprintf("%d", c.receiveCounts[id])),
+		}
+		messages[i] = &m
+
+		if in.VisibilityTimeout != nil {
+			c.visibilityTimeouts[id] = aws.Int64Value(in.VisibilityTimeout)
+		}
+	}
+
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+// DeleteMessage removes the message with the given receipt handle from its
+// queue and marks it deleted.
+func (c *Client) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteLocked(aws.StringValue(in.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageBatch deletes every entry. Client never reports a per-entry
+// failure.
+func (c *Client) DeleteMessageBatch(in *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := &sqs.DeleteMessageBatchOutput{}
+	for _, e := range in.Entries {
+		c.deleteLocked(aws.StringValue(e.ReceiptHandle))
+		out.Successful = append(out.Successful, &sqs.DeleteMessageBatchResultEntry{Id: e.Id})
+	}
+	return out, nil
+}
+
+func (c *Client) deleteLocked(receiptHandle string) {
+	c.deleted[receiptHandle] = true
+	for url, queue := range c.queues {
+		for i, m := range queue {
+			if aws.StringValue(m.ReceiptHandle) == receiptHandle {
+				c.queues[url] = append(queue[:i], queue[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// ChangeMessageVisibility records the visibility timeout requested for a
+// receipt handle; it does not simulate redelivery timing.
+func (c *Client) ChangeMessageVisibility(in *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.visibilityTimeouts[aws.StringValue(in.ReceiptHandle)] = aws.Int64Value(in.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// ReceiveCount returns how many times the message with the given ID has
+// been returned from ReceiveMessage.
+func (c *Client) ReceiveCount(messageID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.receiveCounts[messageID]
+}
+
+// VisibilityTimeout returns the last visibility timeout, in seconds, set for
+// a receipt handle via ReceiveMessage or ChangeMessageVisibility.
+func (c *Client) VisibilityTimeout(receiptHandle string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.visibilityTimeouts[receiptHandle]
+}
+
+// Deleted reports whether DeleteMessage or DeleteMessageBatch has been
+// called for the given receipt handle.
+func (c *Client) Deleted(receiptHandle string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deleted[receiptHandle]
+}
+
+// QueueDepth returns the number of messages still waiting on queueURL.
+func (c *Client) QueueDepth(queueURL string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queues[queueURL])
+}
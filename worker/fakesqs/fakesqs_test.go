@@ -0,0 +1,84 @@
+package fakesqs_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/StirfireStudios/go-sqs-poller/worker/fakesqs"
+)
+
+func TestReceiveMessageIncrementsReceiveCount(t *testing.T) {
+	c := fakesqs.New()
+	id := c.Enqueue("queue-a", "hello")
+
+	for want := 1; want <= 3; want++ {
+		out, err := c.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String("queue-a"),
+			MaxNumberOfMessages: aws.Int64(10),
+		})
+		if err != nil {
+			t.Fatalf("ReceiveMessage: %v", err)
+		}
+		if len(out.Messages) != 1 {
+			t.Fatalf("got %d messages, want 1", len(out.Messages))
+		}
+		if got := c.ReceiveCount(id); got != want {
+			t.Fatalf("ReceiveCount() = %d, want %d", got, want)
+		}
+	}
+}
+
+func TestDeleteMessageBatchMarksEntriesDeleted(t *testing.T) {
+	c := fakesqs.New()
+	c.Enqueue("queue-a", "one")
+	c.Enqueue("queue-a", "two")
+
+	out, err := c.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String("queue-a"),
+		MaxNumberOfMessages: aws.Int64(10),
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage: %v", err)
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(out.Messages))
+	for i, m := range out.Messages {
+		entries[i] = &sqs.DeleteMessageBatchRequestEntry{Id: m.MessageId, ReceiptHandle: m.ReceiptHandle}
+	}
+	if _, err := c.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{Entries: entries}); err != nil {
+		t.Fatalf("DeleteMessageBatch: %v", err)
+	}
+
+	for _, m := range out.Messages {
+		if !c.Deleted(aws.StringValue(m.ReceiptHandle)) {
+			t.Errorf("receipt handle %s not marked deleted", aws.StringValue(m.ReceiptHandle))
+		}
+	}
+}
+
+func TestChangeMessageVisibilityRecordsTimeout(t *testing.T) {
+	c := fakesqs.New()
+	c.Enqueue("queue-a", "hello")
+
+	out, err := c.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String("queue-a"),
+		MaxNumberOfMessages: aws.Int64(10),
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage: %v", err)
+	}
+	handle := out.Messages[0].ReceiptHandle
+
+	if _, err := c.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		ReceiptHandle:     handle,
+		VisibilityTimeout: aws.Int64(42),
+	}); err != nil {
+		t.Fatalf("ChangeMessageVisibility: %v", err)
+	}
+
+	if got := c.VisibilityTimeout(aws.StringValue(handle)); got != 42 {
+		t.Errorf("VisibilityTimeout() = %d, want 42", got)
+	}
+}
@@ -0,0 +1,27 @@
+package worker
+
+import "log"
+
+// LoggerIFace is the logging interface used by the worker for its own
+// diagnostic output. Implement it to route worker logs into the host
+// application's logger of choice.
+type LoggerIFace interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Error(args ...interface{})
+}
+
+// logger is the default LoggerIFace, backed by the standard library logger.
+type logger struct{}
+
+func (l *logger) Debug(args ...interface{}) {
+	log.Println(append([]interface{}{"[DEBUG]"}, args...)...)
+}
+
+func (l *logger) Info(args ...interface{}) {
+	log.Println(append([]interface{}{"[INFO]"}, args...)...)
+}
+
+func (l *logger) Error(args ...interface{}) {
+	log.Println(append([]interface{}{"[ERROR]"}, args...)...)
+}
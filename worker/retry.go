@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsMaxVisibilityTimeout is the upper bound SQS places on a message's
+// visibility timeout. It's used as the default backoff cap when
+// RetryPolicy.BackoffCap is unset, so an uncapped policy still produces a
+// VisibilityTimeout SQS will accept.
+const sqsMaxVisibilityTimeout = 12 * time.Hour
+
+// RetryPolicy controls what happens to a message after its handler returns
+// an error: it is either redelivered with a server-side backoff, or, once
+// received MaxReceives times, routed to a dead-letter queue instead of
+// being redelivered forever.
+type RetryPolicy struct {
+	// MaxReceives is the ApproximateReceiveCount at which a failing message
+	// is routed to DeadLetterQueueURL instead of redelivered again. 0
+	// disables dead-letter routing.
+	MaxReceives int
+	// DeadLetterQueueURL is where messages that reach MaxReceives are sent.
+	// Dead-letter routing only takes effect when both this and MaxReceives
+	// are set.
+	DeadLetterQueueURL string
+	// BackoffBase, if set, makes a failed handler set the message's
+	// visibility timeout to min(BackoffCap, BackoffBase*2^receiveCount)
+	// instead of leaving it at the queue's default, so redeliveries back off
+	// server-side. BackoffCap of 0, or one above SQS's 12-hour maximum, caps
+	// the backoff at 12 hours.
+	BackoffBase time.Duration
+	BackoffCap  time.Duration
+}
+
+// receiveCount reads ApproximateReceiveCount from m's attributes (present
+// only when requested via ReceiveMessageInput.AttributeNames), defaulting to
+// 1 if absent or unparsable.
+func receiveCount(m *sqs.Message) int {
+	v, ok := m.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]
+	if !ok || v == nil {
+		return 1
+	}
+	n, err := strconv.Atoi(aws.StringValue(v))
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+// applyRetryPolicy runs after a handler returns a real (non-InvalidEventError)
+// error. Once the message has been received MaxReceives times it is sent to
+// the dead-letter queue and removed from the source queue; otherwise, if a
+// backoff is configured, its visibility timeout is pushed out so the next
+// redelivery is delayed. handlerErr is always returned unchanged so the
+// caller still logs the original failure.
+func applyRetryPolicy(config *Config, svc SQSClient, m *sqs.Message, deleter *batchDeleter, handlerErr error) error {
+	policy := config.RetryPolicy
+	count := receiveCount(m)
+
+	if policy.MaxReceives > 0 && policy.DeadLetterQueueURL != "" && count >= policy.MaxReceives {
+		if err := sendToDeadLetterQueue(svc, policy.DeadLetterQueueURL, m); err != nil {
+			config.Log.Error(fmt.Sprintf("worker: failed to move message to dead-letter queue: %s", err.Error()))
+			return handlerErr
+		}
+		deleter.add(m)
+		config.Log.Error(fmt.Sprintf("worker: moved message to dead-letter queue after %d receives: %s", count, handlerErr.Error()))
+		return handlerErr
+	}
+
+	if policy.BackoffBase > 0 {
+		maxBackoff := sqsMaxVisibilityTimeout
+		if policy.BackoffCap > 0 && policy.BackoffCap < maxBackoff {
+			maxBackoff = policy.BackoffCap
+		}
+		backoff := backoffFor(policy.BackoffBase, maxBackoff, count)
+
+		_, err := svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+			QueueUrl:          aws.String(config.QueueURL),
+			ReceiptHandle:     m.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(int64(backoff.Seconds())),
+		})
+		if err != nil {
+			config.Log.Error(fmt.Sprintf("worker: failed to back off message visibility: %s", err.Error()))
+		}
+	}
+
+	return handlerErr
+}
+
+// backoffFor returns min(maxBackoff, base*2^count). It doubles base one step
+// at a time and stops as soon as it reaches maxBackoff, so the result can't
+// overflow time.Duration (or go negative) no matter how large count is.
+func backoffFor(base, maxBackoff time.Duration, count int) time.Duration {
+	backoff := base
+	for i := 0; i < count && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// sendToDeadLetterQueue re-sends m's body and attributes to the dead-letter
+// queue, preserving its message attributes.
+func sendToDeadLetterQueue(svc SQSClient, dlqURL string, m *sqs.Message) error {
+	_, err := svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:          aws.String(dlqURL),
+		MessageBody:       m.Body,
+		MessageAttributes: m.MessageAttributes,
+	})
+	return err
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestReceiveCount(t *testing.T) {
+	cases := []struct {
+		name string
+		m    *sqs.Message
+		want int
+	}{
+		{"no attributes", &sqs.Message{}, 1},
+		{
+			"parses the attribute",
+			&sqs.Message{Attributes: map[string]*string{
+				sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3"),
+			}},
+			3,
+		},
+		{
+			"unparsable falls back to 1",
+			&sqs.Message{Attributes: map[string]*string{
+				sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("not-a-number"),
+			}},
+			1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := receiveCount(tc.m); got != tc.want {
+				t.Errorf("receiveCount() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBackoffFor(t *testing.T) {
+	cases := []struct {
+		name       string
+		base       time.Duration
+		maxBackoff time.Duration
+		count      int
+		want       time.Duration
+	}{
+		{"doubles per receive", time.Second, time.Hour, 3, 8 * time.Second},
+		{"clamps to the cap", time.Second, 10 * time.Second, 5, 10 * time.Second},
+		{
+			"a huge count still clamps instead of overflowing",
+			time.Second, sqsMaxVisibilityTimeout, 1 << 30,
+			sqsMaxVisibilityTimeout,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := backoffFor(tc.base, tc.maxBackoff, tc.count); got != tc.want {
+				t.Errorf("backoffFor() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// startVisibilityHeartbeat, if Config.VisibilityTimeout and
+// Config.VisibilityExtensionInterval are both set, starts a goroutine that
+// periodically calls ChangeMessageVisibility to extend m's visibility
+// timeout while its handler is still running, so a slow handler doesn't
+// cause SQS to redeliver the message to another consumer. The returned stop
+// func must be called once the handler returns; it is safe to call even
+// when no heartbeat was started.
+func startVisibilityHeartbeat(ctx context.Context, config *Config, svc SQSClient, m *sqs.Message) (stop func()) {
+	if config.VisibilityTimeout <= 0 || config.VisibilityExtensionInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(config.VisibilityExtensionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				_, err := svc.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          aws.String(config.QueueURL),
+					ReceiptHandle:     m.ReceiptHandle,
+					VisibilityTimeout: aws.Int64(int64(config.VisibilityTimeout.Seconds())),
+				})
+				if err != nil {
+					config.Log.Error(fmt.Sprintf("worker: failed to extend visibility timeout: %s", err.Error()))
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -1,31 +1,120 @@
 package worker
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
-// HandlerFunc is used to define the Handler that is run on for each message
+// Backoff bounds applied to ReceiveMessage retries in StartWithContext.
+const (
+	minReceiveBackoff = 100 * time.Millisecond
+	maxReceiveBackoff = 30 * time.Second
+)
+
+// SQSClient is the subset of *sqs.SQS this package needs. Its methods use
+// the same pointer-args signatures as aws-sdk-go v1, so *sqs.SQS satisfies
+// it with no adapter; it also lets callers substitute a hand-written fake
+// (see the fakesqs subpackage) or a shim over aws-sdk-go-v2 in tests.
+type SQSClient interface {
+	ReceiveMessage(*sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+	DeleteMessageBatch(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+	ChangeMessageVisibility(*sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error)
+	SendMessage(*sqs.SendMessageInput) (*sqs.SendMessageOutput, error)
+}
+
+// HandlerFunc is used to define the Handler that is run on for each message.
+// ctx is ignored, which keeps existing func(msg *sqs.Message) error values
+// assignable to HandlerFunc even though Handler.HandleMessage now takes a
+// context.Context; wrap the func in WithContext instead to observe it.
 type HandlerFunc func(msg *sqs.Message) error
 
+// Middleware wraps a Handler to add cross-cutting behavior around message
+// processing: per-message timeouts, panic recovery, structured logging,
+// retry/backoff decisions, tracing spans, and the like.
+type Middleware func(Handler) Handler
+
 type Config struct {
 	QueueURL string
 	MaxNumberOfMessage int64
 	WaitTimeSecond int64
 	Log LoggerIFace
+	// NumWorkers, when greater than zero, runs a fixed-size pool of
+	// long-lived goroutines to process messages instead of spawning one
+	// goroutine per received message. This bounds the number of goroutines
+	// (and the associated GC pressure) when polling at high throughput. The
+	// zero value keeps the original goroutine-per-message behavior.
+	NumWorkers int
+
+	// DeleteBatchSize is the number of successfully processed messages
+	// accumulated before issuing a single DeleteMessageBatch call. Must be
+	// between 1 and 10 (SQS's DeleteMessageBatch limit); 0 defaults to 10.
+	DeleteBatchSize int
+	// DeleteFlushInterval, if set, flushes a partially-filled delete batch
+	// after this long so messages aren't left queued for deletion
+	// indefinitely when the queue is quiet. 0 disables the timed flush; the
+	// batch is then flushed only when it fills and at the end of each poll
+	// cycle that received messages.
+	DeleteFlushInterval time.Duration
+	// OnDeleteError, if set, is called for each message whose deletion SQS
+	// reported as failed (or that failed to send at all), with the message
+	// ID and the error. Failures are always logged regardless.
+	OnDeleteError func(messageID string, err error)
+
+	// VisibilityTimeout, if set, is sent on the initial ReceiveMessage call
+	// and, together with VisibilityExtensionInterval, enables a heartbeat
+	// that periodically extends a message's visibility timeout for as long
+	// as its handler is still running. Both must be set (and
+	// VisibilityExtensionInterval should be shorter than VisibilityTimeout)
+	// for the heartbeat to take effect.
+	VisibilityTimeout time.Duration
+	// VisibilityExtensionInterval is how often the heartbeat re-extends the
+	// visibility timeout of a message whose handler is still in flight.
+	VisibilityExtensionInterval time.Duration
+
+	// RetryPolicy, if set, routes messages whose handler keeps failing to a
+	// dead-letter queue and/or backs off their redelivery. See RetryPolicy.
+	RetryPolicy RetryPolicy
+
+	middleware []Middleware
+}
+
+// Use registers middleware to wrap the Handler passed to Start or
+// StartWithContext. Middleware is applied in the order given, so the first
+// middleware registered is outermost and sees the message first.
+func (c *Config) Use(mw ...Middleware) {
+	c.middleware = append(c.middleware, mw...)
 }
 
-func (f HandlerFunc) HandleMessage(msg *sqs.Message) error {
+func (f HandlerFunc) HandleMessage(ctx context.Context, msg *sqs.Message) error {
 	return f(msg)
 }
 
+// WithContext adapts a context-aware function into a Handler, for handlers
+// that need ctx (e.g. to respect a per-message timeout set by middleware).
+type WithContext func(ctx context.Context, msg *sqs.Message) error
+
+func (f WithContext) HandleMessage(ctx context.Context, msg *sqs.Message) error {
+	return f(ctx, msg)
+}
+
 // Handler interface
 type Handler interface {
-	HandleMessage(msg *sqs.Message) error
+	HandleMessage(ctx context.Context, msg *sqs.Message) error
+}
+
+// chain wraps h with mw in registration order, so mw[0] is outermost.
+func chain(h Handler, mw []Middleware) Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
 }
 
 type InvalidEventError struct {
@@ -68,12 +157,47 @@ var (
 	}
 )
 
-// Start starts the polling and will continue polling till the application is forcibly stopped
-func Start(config *Config, svc *sqs.SQS, h Handler) {
+// Start starts the polling and will continue polling till the application is forcibly stopped.
+//
+// It is a thin wrapper around StartWithContext using context.Background(), so
+// it never returns. Prefer StartWithContext in services that need to stop
+// polling and drain in-flight messages on shutdown.
+func Start(config *Config, svc SQSClient, h Handler) {
+	_ = StartWithContext(context.Background(), config, svc, h)
+}
+
+// StartWithContext starts the polling loop and runs it until ctx is
+// cancelled. On cancellation it stops issuing new ReceiveMessage calls, waits
+// for the in-flight batch's handlers to finish, and returns ctx.Err().
+//
+// ReceiveMessage errors no longer busy-loop: they're retried with an
+// exponential backoff (with jitter, capped at maxReceiveBackoff) that resets
+// to minReceiveBackoff as soon as a call succeeds.
+func StartWithContext(ctx context.Context, config *Config, svc SQSClient, h Handler) error {
+	if config == nil {
+		config = &DefaultConfig
+	}
+	h = chain(h, config.middleware)
+
+	deleter := newBatchDeleter(config, svc)
+	deleter.startFlushTimer(ctx)
+	defer deleter.flush()
+
+	var jobs chan job
+	if config.NumWorkers > 0 {
+		jobs = make(chan job, config.MaxNumberOfMessage)
+		startWorkerPool(config.NumWorkers, config, svc, h, jobs, deleter)
+		defer close(jobs)
+	}
+
+	backoff := minReceiveBackoff
 	for {
-		if config == nil {
-			config = &DefaultConfig
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
 		}
+
 		config.Log.Debug("worker: Start Polling")
 		params := &sqs.ReceiveMessageInput{
 			QueueUrl:            aws.String(config.QueueURL), // Required
@@ -81,22 +205,55 @@ func Start(config *Config, svc *sqs.SQS, h Handler) {
 			MessageAttributeNames: []*string{
 				aws.String("All"), // Required
 			},
+			AttributeNames: []*string{
+				aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount),
+			},
 			WaitTimeSeconds: aws.Int64(config.WaitTimeSecond),
 		}
+		if config.VisibilityTimeout > 0 {
+			params.VisibilityTimeout = aws.Int64(int64(config.VisibilityTimeout.Seconds()))
+		}
 
 		resp, err := svc.ReceiveMessage(params)
 		if err != nil {
-			log.Println(err)
+			config.Log.Error(err.Error())
+			select {
+			case <-time.After(jitter(backoff)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if backoff *= 2; backoff > maxReceiveBackoff {
+				backoff = maxReceiveBackoff
+			}
 			continue
 		}
+		backoff = minReceiveBackoff
+
 		if len(resp.Messages) > 0 {
-			run(config, svc, h, resp.Messages)
+			if jobs != nil {
+				runPool(ctx, config, jobs, resp.Messages)
+			} else {
+				run(ctx, config, svc, h, resp.Messages, deleter)
+			}
+			// Flush whatever this poll cycle queued for deletion, even if it
+			// didn't fill a full batch, so successes aren't left undeleted
+			// (and redelivered) on a low-traffic queue.
+			deleter.flush()
 		}
 	}
 }
 
+// jitter returns a random duration in [d/2, d], so that many pollers backing
+// off at the same time don't all retry ReceiveMessage in lockstep.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
 // poll launches goroutine per received message and wait for all message to be processed
-func run(config *Config, svc *sqs.SQS, h Handler, messages []*sqs.Message) {
+func run(ctx context.Context, config *Config, svc SQSClient, h Handler, messages []*sqs.Message, deleter *batchDeleter) {
 	numMessages := len(messages)
 	config.Log.Info(fmt.Sprintf("worker: Received %d messages", numMessages))
 
@@ -106,7 +263,7 @@ func run(config *Config, svc *sqs.SQS, h Handler, messages []*sqs.Message) {
 		go func(m *sqs.Message) {
 			// launch goroutine
 			defer wg.Done()
-			if err := handleMessage(config, svc, m, h); err != nil {
+			if err := handleMessage(ctx, config, svc, m, h, deleter); err != nil {
 				config.Log.Error(err.Error())
 			}
 		}(messages[i])
@@ -115,24 +272,58 @@ func run(config *Config, svc *sqs.SQS, h Handler, messages []*sqs.Message) {
 	wg.Wait()
 }
 
-func handleMessage(config *Config, svc *sqs.SQS, m *sqs.Message, h Handler) error {
-	var err error
-	err = h.HandleMessage(m)
+// job is a unit of work handed to a pool worker by runPool. wg is the
+// WaitGroup for the batch the message was received in; the worker calls
+// wg.Done() once the message has been handled, regardless of outcome.
+type job struct {
+	ctx context.Context
+	msg *sqs.Message
+	wg  *sync.WaitGroup
+}
+
+// startWorkerPool launches n long-lived goroutines that pull jobs off jobs
+// and process them with handleMessage until jobs is closed.
+func startWorkerPool(n int, config *Config, svc SQSClient, h Handler, jobs <-chan job, deleter *batchDeleter) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for j := range jobs {
+				if err := handleMessage(j.ctx, config, svc, j.msg, h, deleter); err != nil {
+					config.Log.Error(err.Error())
+				}
+				j.wg.Done()
+			}
+		}()
+	}
+}
+
+// runPool hands messages to the long-lived worker pool via jobs and blocks
+// until the whole batch has been processed, so the caller doesn't issue the
+// next ReceiveMessage while workers are still catching up.
+func runPool(ctx context.Context, config *Config, jobs chan<- job, messages []*sqs.Message) {
+	numMessages := len(messages)
+	config.Log.Info(fmt.Sprintf("worker: Received %d messages", numMessages))
+
+	var wg sync.WaitGroup
+	wg.Add(numMessages)
+	for i := range messages {
+		jobs <- job{ctx: ctx, msg: messages[i], wg: &wg}
+	}
+	wg.Wait()
+}
+
+func handleMessage(ctx context.Context, config *Config, svc SQSClient, m *sqs.Message, h Handler, deleter *batchDeleter) error {
+	stopHeartbeat := startVisibilityHeartbeat(ctx, config, svc, m)
+	defer stopHeartbeat()
+
+	err := h.HandleMessage(ctx, m)
 	if _, ok := err.(InvalidEventError); ok {
 		config.Log.Error(err.Error())
 	} else if err != nil {
-		return err
+		return applyRetryPolicy(config, svc, m, deleter, err)
 	}
 
-	params := &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(config.QueueURL), // Required
-		ReceiptHandle: m.ReceiptHandle,      // Required
-	}
-	_, err = svc.DeleteMessage(params)
-	if err != nil {
-		return err
-	}
-	config.Log.Debug(fmt.Sprintf("worker: deleted message from queue: %s", aws.StringValue(m.ReceiptHandle)))
+	deleter.add(m)
+	config.Log.Debug(fmt.Sprintf("worker: queued message for batch delete: %s", aws.StringValue(m.ReceiptHandle)))
 
 	return nil
 }
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// errSkipDelete is returned by the benchmark handler so handleMessage
+// returns before touching svc, letting the benchmarks run with a nil
+// SQSClient and isolate the dispatch overhead being compared.
+var errSkipDelete = errors.New("worker: benchmark handler, no delete")
+
+func benchMessages(n int) []*sqs.Message {
+	messages := make([]*sqs.Message, n)
+	for i := range messages {
+		messages[i] = &sqs.Message{}
+	}
+	return messages
+}
+
+// BenchmarkRunGoroutinePerMessage measures the original run() path, which
+// spawns one goroutine per message in the batch.
+func BenchmarkRunGoroutinePerMessage(b *testing.B) {
+	config := &Config{Log: &logger{}}
+	h := HandlerFunc(func(msg *sqs.Message) error { return errSkipDelete })
+	messages := benchMessages(100)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		run(context.Background(), config, nil, h, messages, nil)
+	}
+}
+
+// BenchmarkRunWorkerPool measures the bounded worker pool path for the same
+// batch size and worker count, for comparison against goroutine-per-message.
+func BenchmarkRunWorkerPool(b *testing.B) {
+	config := &Config{Log: &logger{}}
+	h := HandlerFunc(func(msg *sqs.Message) error { return errSkipDelete })
+	messages := benchMessages(100)
+
+	jobs := make(chan job, len(messages))
+	startWorkerPool(10, config, nil, h, jobs, nil)
+	defer close(jobs)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runPool(context.Background(), config, jobs, messages)
+	}
+}
@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/StirfireStudios/go-sqs-poller/worker/fakesqs"
+)
+
+// Compile-time assertions that both the real SQS client and the in-memory
+// fake satisfy SQSClient.
+var (
+	_ SQSClient = (*sqs.SQS)(nil)
+	_ SQSClient = (*fakesqs.Client)(nil)
+)
+
+// recoverMiddleware turns a panic in the wrapped handler into a returned
+// error, the way a panic-recovery middleware is expected to behave.
+func recoverMiddleware(next Handler) Handler {
+	return WithContext(func(ctx context.Context, msg *sqs.Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.New("recovered from panic")
+			}
+		}()
+		return next.HandleMessage(ctx, msg)
+	})
+}
+
+func TestChainOrdersMiddlewareOutermostFirst(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return WithContext(func(ctx context.Context, msg *sqs.Message) error {
+				order = append(order, name)
+				return next.HandleMessage(ctx, msg)
+			})
+		}
+	}
+
+	h := chain(HandlerFunc(func(msg *sqs.Message) error { return nil }), []Middleware{mark("first"), mark("second")})
+	if err := h.HandleMessage(context.Background(), &sqs.Message{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got, want := order, []string{"first", "second"}; !equalStrings(got, want) {
+		t.Fatalf("middleware ran in order %v, want %v", got, want)
+	}
+}
+
+func TestRecoverMiddlewareTurnsPanicIntoError(t *testing.T) {
+	panicky := HandlerFunc(func(msg *sqs.Message) error { panic("boom") })
+	h := chain(panicky, []Middleware{recoverMiddleware})
+
+	if err := h.HandleMessage(context.Background(), &sqs.Message{}); err == nil {
+		t.Fatal("expected an error from a recovered panic, got nil")
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}